@@ -0,0 +1,446 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"code.gitea.io/sdk/gitea"
+	"github.com/google/go-github/v27/github"
+	"golang.org/x/oauth2"
+)
+
+// buildGitHubClient builds an authenticated GitHub (or GitHub Enterprise,
+// when cfg.APIURL is set and cfg.Forge is "ghe") client from an
+// already-resolved cfg.Token. Unlike newGitHubClient it never prompts for
+// credentials, so it is also used by serve, which has no interactive
+// terminal.
+func buildGitHubClient(cfg Config) (*github.Client, error) {
+	tc := oauth2.NewClient(context.Background(), oauth2.StaticTokenSource(
+		&oauth2.Token{AccessToken: cfg.Token},
+	))
+	if cfg.Forge == "ghe" {
+		return github.NewEnterpriseClient(cfg.APIURL, cfg.APIURL, tc)
+	}
+	return github.NewClient(tc), nil
+}
+
+// buildGiteaClient builds an authenticated Gitea client from an
+// already-resolved cfg.Token. See buildGitHubClient.
+func buildGiteaClient(cfg Config) (*gitea.Client, error) {
+	return gitea.NewClient(cfg.APIURL, gitea.SetToken(cfg.Token))
+}
+
+// buildForge builds the Forge selected by cfg.Forge from an
+// already-resolved cfg.Token, without ever prompting for credentials.
+// ghClient is additionally populated for github/ghe, mirroring setup's
+// ghClient return, since release management is not part of the Forge
+// abstraction.
+func buildForge(cfg Config) (forge Forge, ghClient *github.Client, err error) {
+	switch cfg.Forge {
+	case "github", "ghe":
+		ghClient, err = buildGitHubClient(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		return NewGitHubForge(ghClient), ghClient, nil
+	case "gitea":
+		giteaClient, err := buildGiteaClient(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		return NewGiteaForge(giteaClient, cfg.APIURL), nil, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown forge %q (want \"github\", \"ghe\" or \"gitea\")", cfg.Forge)
+	}
+}
+
+// PullRequest is a forge-agnostic view of a pull request, translated from
+// whichever forge's native representation by that forge's implementation.
+type PullRequest struct {
+	Number         int
+	Title          string
+	Body           string
+	State          string
+	Base           string
+	Head           string
+	MergeCommitSHA string
+	HTMLURL        string
+	Labels         []string
+	User           struct {
+		Login string
+	}
+}
+
+// GetNumber, GetTitle, GetBody and GetHTMLURL are nil-safe accessors,
+// mirroring the style of the generated go-github getters so call sites
+// don't need to nil-check a possibly-absent releasePR.
+func (pr *PullRequest) GetNumber() int {
+	if pr == nil {
+		return 0
+	}
+	return pr.Number
+}
+
+func (pr *PullRequest) GetTitle() string {
+	if pr == nil {
+		return ""
+	}
+	return pr.Title
+}
+
+func (pr *PullRequest) GetBody() string {
+	if pr == nil {
+		return ""
+	}
+	return pr.Body
+}
+
+func (pr *PullRequest) GetHTMLURL() string {
+	if pr == nil {
+		return ""
+	}
+	return pr.HTMLURL
+}
+
+// NewPullRequest holds the fields needed to open a pull request on any
+// forge.
+type NewPullRequest struct {
+	Title string
+	Body  string
+	Head  string
+	Base  string
+}
+
+// PullRequestListOptions filters PullRequests.ListPullRequests. Base and
+// Head are plain branch names; an empty field means "no filter on this
+// field".
+type PullRequestListOptions struct {
+	State string // "open", "closed" or "all"
+	Base  string
+	Head  string
+	// StopEarly, if set, is called with the results accumulated so far
+	// after each page is fetched; once it reports true, pagination stops
+	// even if more pages remain. This lets a caller that only wants a
+	// known subset of pull requests (e.g. those matching a set of merge
+	// commit SHAs or PR numbers) avoid draining the full result set.
+	StopEarly func(soFar []*PullRequest) bool
+}
+
+// Forge abstracts the pull request and commit operations gh-pr-release
+// needs from a git forge, so that GitHub, GitHub Enterprise and Gitea can
+// share the same release-PR logic.
+type Forge interface {
+	// CompareCommits returns the SHAs of the commits that are in head but
+	// not in base.
+	CompareCommits(ctx context.Context, owner, repo, base, head string) ([]string, error)
+	ListPullRequests(ctx context.Context, owner, repo string, opt PullRequestListOptions) ([]*PullRequest, error)
+	CreatePullRequest(ctx context.Context, owner, repo string, newPR NewPullRequest) (*PullRequest, error)
+	EditPullRequest(ctx context.Context, owner, repo string, number int, pr *PullRequest) (*PullRequest, error)
+	AddLabels(ctx context.Context, owner, repo string, number int, labels []string) error
+	// GetBranchSHA returns the SHA of the commit branch currently points to.
+	GetBranchSHA(ctx context.Context, owner, repo, branch string) (string, error)
+	// AddComment posts a comment on the pull request or issue numbered number.
+	AddComment(ctx context.Context, owner, repo string, number int, body string) error
+	// CompareURL returns a web URL comparing base..head, for use in
+	// generated release descriptions.
+	CompareURL(ctx context.Context, owner, repo, base, head string) (string, error)
+}
+
+// GitHubForge implements Forge against github.com or a GitHub Enterprise
+// instance.
+type GitHubForge struct {
+	client *github.Client
+}
+
+// NewGitHubForge wraps an already-authenticated github.Client.
+func NewGitHubForge(client *github.Client) *GitHubForge {
+	return &GitHubForge{client: client}
+}
+
+func (f *GitHubForge) CompareCommits(ctx context.Context, owner, repo, base, head string) ([]string, error) {
+	comparison, _, err := f.client.Repositories.CompareCommits(ctx, owner, repo, base, head)
+	if err != nil {
+		return nil, err
+	}
+	shas := make([]string, 0, len(comparison.Commits))
+	for _, c := range comparison.Commits {
+		if c.SHA != nil {
+			shas = append(shas, *c.SHA)
+		}
+	}
+	return shas, nil
+}
+
+func (f *GitHubForge) ListPullRequests(ctx context.Context, owner, repo string, opt PullRequestListOptions) ([]*PullRequest, error) {
+	listOpt := &github.PullRequestListOptions{
+		State:       opt.State,
+		Base:        opt.Base,
+		Sort:        "created",
+		Direction:   "desc",
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	if opt.Head != "" {
+		listOpt.Head = fmt.Sprintf("%s:%s", owner, opt.Head)
+	}
+	var all []*PullRequest
+	for {
+		prs, resp, err := f.client.PullRequests.List(ctx, owner, repo, listOpt)
+		if err != nil {
+			return nil, err
+		}
+		for _, pr := range prs {
+			all = append(all, fromGitHubPullRequest(pr))
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		if opt.StopEarly != nil && opt.StopEarly(all) {
+			break
+		}
+		listOpt.Page = resp.NextPage
+	}
+	return all, nil
+}
+
+func (f *GitHubForge) CreatePullRequest(ctx context.Context, owner, repo string, newPR NewPullRequest) (*PullRequest, error) {
+	pr, _, err := f.client.PullRequests.Create(ctx, owner, repo, &github.NewPullRequest{
+		Title: &newPR.Title,
+		Body:  &newPR.Body,
+		Head:  &newPR.Head,
+		Base:  &newPR.Base,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fromGitHubPullRequest(pr), nil
+}
+
+func (f *GitHubForge) EditPullRequest(ctx context.Context, owner, repo string, number int, pr *PullRequest) (*PullRequest, error) {
+	updated, _, err := f.client.PullRequests.Edit(ctx, owner, repo, number, &github.PullRequest{
+		Title: &pr.Title,
+		Body:  &pr.Body,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fromGitHubPullRequest(updated), nil
+}
+
+func (f *GitHubForge) AddLabels(ctx context.Context, owner, repo string, number int, labels []string) error {
+	_, _, err := f.client.Issues.AddLabelsToIssue(ctx, owner, repo, number, labels)
+	return err
+}
+
+func (f *GitHubForge) GetBranchSHA(ctx context.Context, owner, repo, branch string) (string, error) {
+	b, _, err := f.client.Repositories.GetBranch(ctx, owner, repo, branch)
+	if err != nil {
+		return "", err
+	}
+	return b.GetCommit().GetSHA(), nil
+}
+
+func (f *GitHubForge) AddComment(ctx context.Context, owner, repo string, number int, body string) error {
+	_, _, err := f.client.Issues.CreateComment(ctx, owner, repo, number, &github.IssueComment{Body: &body})
+	return err
+}
+
+func (f *GitHubForge) CompareURL(ctx context.Context, owner, repo, base, head string) (string, error) {
+	comparison, _, err := f.client.Repositories.CompareCommits(ctx, owner, repo, base, head)
+	if err != nil {
+		return "", err
+	}
+	return comparison.GetHTMLURL(), nil
+}
+
+func fromGitHubPullRequest(pr *github.PullRequest) *PullRequest {
+	out := &PullRequest{
+		Number:  pr.GetNumber(),
+		Title:   pr.GetTitle(),
+		Body:    pr.GetBody(),
+		State:   pr.GetState(),
+		HTMLURL: pr.GetHTMLURL(),
+	}
+	out.User.Login = pr.GetUser().GetLogin()
+	for _, label := range pr.Labels {
+		out.Labels = append(out.Labels, label.GetName())
+	}
+	if pr.Base != nil {
+		out.Base = pr.Base.GetRef()
+	}
+	if pr.Head != nil {
+		out.Head = pr.Head.GetRef()
+	}
+	if pr.MergeCommitSHA != nil {
+		out.MergeCommitSHA = *pr.MergeCommitSHA
+	}
+	return out
+}
+
+// GiteaForge implements Forge against a Gitea instance.
+type GiteaForge struct {
+	client *gitea.Client
+	apiURL string
+}
+
+// NewGiteaForge wraps an already-authenticated gitea.Client. apiURL is the
+// same URL the client was constructed with, kept alongside it since the
+// Gitea SDK does not expose it back and CompareURL needs to derive a web
+// URL from it.
+func NewGiteaForge(client *gitea.Client, apiURL string) *GiteaForge {
+	return &GiteaForge{client: client, apiURL: apiURL}
+}
+
+func (f *GiteaForge) CompareCommits(ctx context.Context, owner, repo, base, head string) ([]string, error) {
+	compare, _, err := f.client.CompareCommits(owner, repo, base, head)
+	if err != nil {
+		return nil, err
+	}
+	shas := make([]string, 0, len(compare.Commits))
+	for _, c := range compare.Commits {
+		shas = append(shas, c.SHA)
+	}
+	return shas, nil
+}
+
+func (f *GiteaForge) ListPullRequests(ctx context.Context, owner, repo string, opt PullRequestListOptions) ([]*PullRequest, error) {
+	state := gitea.StateOpen
+	switch opt.State {
+	case "closed":
+		state = gitea.StateClosed
+	case "all":
+		state = gitea.StateAll
+	}
+	listOpt := gitea.ListPullRequestsOptions{State: state}
+	listOpt.Page = 1
+	listOpt.PageSize = 50
+
+	var all []*PullRequest
+	for {
+		prs, resp, err := f.client.ListRepoPullRequests(owner, repo, listOpt)
+		if err != nil {
+			return nil, err
+		}
+		for _, pr := range prs {
+			if opt.Base != "" && (pr.Base == nil || pr.Base.Ref != opt.Base) {
+				continue
+			}
+			if opt.Head != "" && (pr.Head == nil || pr.Head.Ref != opt.Head) {
+				continue
+			}
+			all = append(all, fromGiteaPullRequest(pr))
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		if opt.StopEarly != nil && opt.StopEarly(all) {
+			break
+		}
+		listOpt.Page = resp.NextPage
+	}
+	return all, nil
+}
+
+func (f *GiteaForge) CreatePullRequest(ctx context.Context, owner, repo string, newPR NewPullRequest) (*PullRequest, error) {
+	pr, _, err := f.client.CreatePullRequest(owner, repo, gitea.CreatePullRequestOption{
+		Title: newPR.Title,
+		Body:  newPR.Body,
+		Head:  newPR.Head,
+		Base:  newPR.Base,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fromGiteaPullRequest(pr), nil
+}
+
+func (f *GiteaForge) EditPullRequest(ctx context.Context, owner, repo string, number int, pr *PullRequest) (*PullRequest, error) {
+	updated, _, err := f.client.EditPullRequest(owner, repo, int64(number), gitea.EditPullRequestOption{
+		Title: pr.Title,
+		Body:  pr.Body,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fromGiteaPullRequest(updated), nil
+}
+
+func (f *GiteaForge) AddLabels(ctx context.Context, owner, repo string, number int, labels []string) error {
+	ids, err := f.resolveLabelIDs(owner, repo, labels)
+	if err != nil {
+		return err
+	}
+	_, _, err = f.client.AddIssueLabels(owner, repo, int64(number), gitea.IssueLabelsOption{Labels: ids})
+	return err
+}
+
+func (f *GiteaForge) GetBranchSHA(ctx context.Context, owner, repo, branch string) (string, error) {
+	b, _, err := f.client.GetRepoBranch(owner, repo, branch)
+	if err != nil {
+		return "", err
+	}
+	if b.Commit == nil {
+		return "", nil
+	}
+	return b.Commit.ID, nil
+}
+
+func (f *GiteaForge) AddComment(ctx context.Context, owner, repo string, number int, body string) error {
+	_, _, err := f.client.CreateIssueComment(owner, repo, int64(number), gitea.CreateIssueCommentOption{Body: body})
+	return err
+}
+
+// CompareURL builds a web comparison URL from apiURL, since the Gitea SDK's
+// CompareCommits response carries no HTML URL of its own (unlike GitHub's).
+func (f *GiteaForge) CompareURL(ctx context.Context, owner, repo, base, head string) (string, error) {
+	webURL := strings.TrimSuffix(f.apiURL, "/api/v1")
+	return fmt.Sprintf("%s/%s/%s/compare/%s...%s", webURL, owner, repo, base, head), nil
+}
+
+// resolveLabelIDs looks up the repo's labels by name, since Gitea's issue
+// API takes label IDs rather than names.
+func (f *GiteaForge) resolveLabelIDs(owner, repo string, names []string) ([]int64, error) {
+	repoLabels, _, err := f.client.ListRepoLabels(owner, repo, gitea.ListLabelsOptions{})
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]int64, len(repoLabels))
+	for _, l := range repoLabels {
+		byName[l.Name] = l.ID
+	}
+	ids := make([]int64, 0, len(names))
+	for _, name := range names {
+		id, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("gitea: label %q not found in %s/%s", name, owner, repo)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func fromGiteaPullRequest(pr *gitea.PullRequest) *PullRequest {
+	out := &PullRequest{
+		Number:  int(pr.Index),
+		Title:   pr.Title,
+		Body:    pr.Body,
+		State:   string(pr.State),
+		HTMLURL: pr.HTMLURL,
+	}
+	if pr.Poster != nil {
+		out.User.Login = pr.Poster.UserName
+	}
+	for _, label := range pr.Labels {
+		out.Labels = append(out.Labels, label.Name)
+	}
+	if pr.Base != nil {
+		out.Base = pr.Base.Ref
+	}
+	if pr.Head != nil {
+		out.Head = pr.Head.Ref
+	}
+	if pr.MergedCommitID != nil {
+		out.MergeCommitSHA = *pr.MergedCommitID
+	}
+	return out
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
@@ -16,6 +17,7 @@ import (
 	"text/template"
 	"time"
 
+	"code.gitea.io/sdk/gitea"
 	"github.com/BurntSushi/toml"
 	"github.com/Songmu/prompter"
 	"github.com/aita/go-diff-lcs/diff"
@@ -24,7 +26,6 @@ import (
 	homedir "github.com/mitchellh/go-homedir"
 	flag "github.com/spf13/pflag"
 	"go.uber.org/multierr"
-	"golang.org/x/oauth2"
 	"gopkg.in/go-playground/validator.v9"
 )
 
@@ -33,16 +34,48 @@ const (
 	title   = `Release {{.ReleaseAt.Format "2006-01-02 15:04:05 -0700"}}`
 	body    = `{{ range .PullRequests }}* [ ] #{{ .Number }} {{ .Title }} @{{ .User.Login }}
 {{ end }}`
+	tagTemplate = `v{{.Version}}`
 )
 
 var (
 	configHomePath   string
 	globalConfigPath string
-
-	debug      = flag.Bool("debug", false, "print debug information")
-	configPath = flag.String("config", fmt.Sprintf("%s.toml", appName), "configuration file path")
 )
 
+// commonFlags are accepted by every subcommand.
+type commonFlags struct {
+	debug      bool
+	configPath string
+}
+
+func newCommonFlagSet(name string) (*flag.FlagSet, *commonFlags) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	f := &commonFlags{}
+	fs.BoolVar(&f.debug, "debug", false, "print debug information")
+	fs.StringVar(&f.configPath, "config", fmt.Sprintf("%s.toml", appName), "configuration file path")
+	return fs, f
+}
+
+// publishFlags are accepted by the "publish" subcommand in addition to the
+// commonFlags.
+type publishFlags struct {
+	*commonFlags
+	bump       string
+	draft      bool
+	prerelease bool
+	assets     []string
+}
+
+func newPublishFlagSet() (*flag.FlagSet, *publishFlags) {
+	fs, common := newCommonFlagSet("publish")
+	f := &publishFlags{commonFlags: common}
+	fs.StringVar(&f.bump, "bump", "", "semver bump to apply: major, minor or patch (default \"patch\")")
+	fs.BoolVar(&f.draft, "draft", false, "create the release as a draft")
+	fs.BoolVar(&f.prerelease, "prerelease", false, "mark the release as a prerelease")
+	fs.StringArrayVar(&f.assets, "asset", nil, "path to a local file to upload as a release asset (may be repeated)")
+	return fs, f
+}
+
 func init() {
 	configHomePath := os.Getenv("XDG_CONFIG_HOME")
 	if configHomePath == "" {
@@ -56,23 +89,75 @@ func init() {
 }
 
 type Config struct {
-	Token  string   `validate:"-"`
-	Owner  string   `validate:"required"`
-	Repo   string   `validate:"required"`
-	Base   string   `validate:"required"`
-	Head   string   `validate:"required"`
-	Title  string   `validate:"required"`
-	Body   string   `validate:"required"`
-	Labels []string `validate:"-"`
+	Token               string            `validate:"-"`
+	Owner               string            `validate:"required"`
+	Repo                string            `validate:"required"`
+	Base                string            `validate:"required"`
+	Head                string            `validate:"required"`
+	Title               string            `validate:"required"`
+	Body                string            `validate:"required"`
+	Labels              []string          `validate:"-"`
+	TagTemplate         string            `validate:"required"`
+	Forge               string            `validate:"required,oneof=github ghe gitea"`
+	APIURL              string            `validate:"-"`
+	SemverLabels        map[string]string `validate:"-"`
+	ReleaseBranchPrefix string            `validate:"-"`
 }
 
-func loadConfig(localConfigPath string) (cfg Config, err error) {
-	cfg = Config{
-		Base:  "master",
-		Head:  "develop",
-		Title: title,
-		Body:  body,
+// defaultConfig returns the baseline Config values applied before any TOML
+// file or environment variable is layered on top.
+func defaultConfig() Config {
+	return Config{
+		Base:        "master",
+		Head:        "develop",
+		Title:       title,
+		Body:        body,
+		TagTemplate: tagTemplate,
+		Forge:       "github",
+		SemverLabels: map[string]string{
+			"breaking": "major",
+			"feature":  "minor",
+			"fix":      "patch",
+		},
+		ReleaseBranchPrefix: "release/",
+	}
+}
+
+// applyConfigDefaults fills in any field of cfg left at its zero value with
+// the corresponding defaultConfig() value. It is used to default per-repo
+// Config entries decoded from a serve config's repos table, which (unlike
+// loadConfig) aren't seeded from defaultConfig() before decoding.
+func applyConfigDefaults(cfg Config) Config {
+	def := defaultConfig()
+	if cfg.Base == "" {
+		cfg.Base = def.Base
+	}
+	if cfg.Head == "" {
+		cfg.Head = def.Head
+	}
+	if cfg.Title == "" {
+		cfg.Title = def.Title
 	}
+	if cfg.Body == "" {
+		cfg.Body = def.Body
+	}
+	if cfg.TagTemplate == "" {
+		cfg.TagTemplate = def.TagTemplate
+	}
+	if cfg.Forge == "" {
+		cfg.Forge = def.Forge
+	}
+	if cfg.SemverLabels == nil {
+		cfg.SemverLabels = def.SemverLabels
+	}
+	if cfg.ReleaseBranchPrefix == "" {
+		cfg.ReleaseBranchPrefix = def.ReleaseBranchPrefix
+	}
+	return cfg
+}
+
+func loadConfig(localConfigPath string) (cfg Config, err error) {
+	cfg = defaultConfig()
 	for _, path := range []string{globalConfigPath, localConfigPath} {
 		_, err = toml.DecodeFile(path, &cfg)
 		if os.IsNotExist(err) {
@@ -85,24 +170,66 @@ func loadConfig(localConfigPath string) (cfg Config, err error) {
 	if err = envconfig.Process(strings.ReplaceAll(appName, "-", "_"), &cfg); err != nil {
 		return
 	}
+	if cfg, err = loadConfigTemplates(cfg, filepath.Dir(localConfigPath)); err != nil {
+		return
+	}
 	return
 }
 
-func createToken(ctx context.Context) (string, error) {
+// loadConfigTemplates resolves an @-prefixed Config.Title or Config.Body
+// to the contents of the file it names, resolved relative to configDir.
+// Values not starting with "@" are left untouched.
+func loadConfigTemplates(cfg Config, configDir string) (Config, error) {
+	var err error
+	if cfg.Title, err = loadTemplateField(cfg.Title, configDir); err != nil {
+		return cfg, err
+	}
+	if cfg.Body, err = loadTemplateField(cfg.Body, configDir); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+func loadTemplateField(value, configDir string) (string, error) {
+	if !strings.HasPrefix(value, "@") {
+		return value, nil
+	}
+	path := strings.TrimPrefix(value, "@")
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(configDir, path)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func promptUsernamePassword() (username, password string) {
 	defaultUsername := ""
 	u, err := user.Current()
 	if err == nil {
 		defaultUsername = u.Username
 	}
-	username := prompter.Prompt("Username", defaultUsername)
-	password := prompter.Password("Password")
+	username = prompter.Prompt("Username", defaultUsername)
+	password = prompter.Password("Password")
+	return username, password
+}
+
+// createGitHubToken creates a new personal access token via the GitHub (or
+// GitHub Enterprise, when apiURL is set) Authorizations API.
+func createGitHubToken(ctx context.Context, apiURL string) (string, error) {
+	username, password := promptUsernamePassword()
 
 	// Create a new client using HTTP Basic Authentication to create new GitHub API Token
 	basicAuth := github.BasicAuthTransport{
 		Username: username,
 		Password: password,
 	}
-	client := github.NewClient(basicAuth.Client())
+	client, err := newGitHubClientForAuth(basicAuth.Client(), apiURL)
+	if err != nil {
+		return "", err
+	}
 	note := appName
 	authReq := &github.AuthorizationRequest{
 		Scopes: []github.Scope{github.ScopeRepo},
@@ -112,7 +239,10 @@ func createToken(ctx context.Context) (string, error) {
 	if res.StatusCode == http.StatusUnauthorized && strings.Contains(res.Header.Get("x-github-otp"), "required") {
 		// Retry with two-factor authentication OTP code.
 		basicAuth.OTP = prompter.Prompt("Two-factor authentication OTP code", "")
-		client = github.NewClient(basicAuth.Client())
+		client, err = newGitHubClientForAuth(basicAuth.Client(), apiURL)
+		if err != nil {
+			return "", err
+		}
 		auth, res, err = client.Authorizations.Create(ctx, authReq)
 	}
 	if err != nil {
@@ -121,6 +251,32 @@ func createToken(ctx context.Context) (string, error) {
 	return auth.GetToken(), nil
 }
 
+func newGitHubClientForAuth(httpClient *http.Client, apiURL string) (*github.Client, error) {
+	if apiURL == "" {
+		return github.NewClient(httpClient), nil
+	}
+	return github.NewEnterpriseClient(apiURL, apiURL, httpClient)
+}
+
+// createGiteaToken creates a new personal access token via the Gitea access
+// token API, since Gitea has no equivalent of GitHub's Authorizations API.
+func createGiteaToken(apiURL string) (string, error) {
+	username, password := promptUsernamePassword()
+
+	client, err := gitea.NewClient(apiURL, gitea.SetBasicAuth(username, password))
+	if err != nil {
+		return "", err
+	}
+	token, _, err := client.CreateAccessToken(gitea.CreateAccessTokenOption{
+		Name:   appName,
+		Scopes: []gitea.AccessTokenScope{gitea.AccessTokenScopeRepo},
+	})
+	if err != nil {
+		return "", err
+	}
+	return token.Token, nil
+}
+
 func exists(path string) bool {
 	_, err := os.Stat(path)
 	return !os.IsNotExist(err)
@@ -157,43 +313,42 @@ func saveToken(token string) (err error) {
 	return
 }
 
-func findMergedPullRequests(ctx context.Context, cfg Config, client *github.Client) ([]*github.PullRequest, error) {
-	// List merged pull requests into the base branch
-	comparison, _, err := client.Repositories.CompareCommits(context.Background(), cfg.Owner, cfg.Repo, cfg.Base, cfg.Head)
+func findMergedPullRequests(ctx context.Context, cfg Config, forge Forge) ([]*PullRequest, error) {
+	// List the commits that merging the head branch into the base branch
+	// would bring in
+	shas, err := forge.CompareCommits(ctx, cfg.Owner, cfg.Repo, cfg.Base, cfg.Head)
 	if err != nil {
 		return nil, err
 	}
 	hashes := map[string]bool{}
-	for _, c := range comparison.Commits {
-		if c.SHA != nil {
-			hashes[*c.SHA] = true
-		}
+	for _, sha := range shas {
+		hashes[sha] = true
 	}
-	mergedPRs := []*github.PullRequest{}
-	opt := &github.PullRequestListOptions{
-		State:     "closed",
-		Base:      cfg.Head,
-		Sort:      "created",
-		Direction: "desc",
-		ListOptions: github.ListOptions{
-			PerPage: 100,
+
+	prs, err := forge.ListPullRequests(ctx, cfg.Owner, cfg.Repo, PullRequestListOptions{
+		State: "closed",
+		Base:  cfg.Head,
+		// Pull requests are listed newest-created first, so once every
+		// commit SHA from the comparison has turned up as some PR's merge
+		// commit, later pages can only contain older, irrelevant PRs.
+		StopEarly: func(soFar []*PullRequest) bool {
+			found := 0
+			for _, pr := range soFar {
+				if pr.MergeCommitSHA != "" && hashes[pr.MergeCommitSHA] {
+					found++
+				}
+			}
+			return found >= len(hashes)
 		},
+	})
+	if err != nil {
+		return nil, err
 	}
-	for {
-		prs, resp, err := client.PullRequests.List(context.Background(), cfg.Owner, cfg.Repo, opt)
-		if err != nil {
-			return nil, err
+	var mergedPRs []*PullRequest
+	for _, pr := range prs {
+		if pr.MergeCommitSHA != "" && hashes[pr.MergeCommitSHA] {
+			mergedPRs = append(mergedPRs, pr)
 		}
-		for _, pr := range prs {
-			if pr.MergeCommitSHA != nil && hashes[*pr.MergeCommitSHA] {
-				mergedPRs = append(mergedPRs, pr)
-				delete(hashes, *pr.MergeCommitSHA)
-			}
-		}
-		if len(hashes) == 0 || resp.NextPage == 0 {
-			break
-		}
-		opt.Page = resp.NextPage
 	}
 	sort.Slice(mergedPRs, func(i, j int) bool {
 		return mergedPRs[i].GetNumber() < mergedPRs[j].GetNumber()
@@ -206,8 +361,12 @@ type Description struct {
 	Body  string
 }
 
-func buildDescription(cfg Config, mergedPRs []*github.PullRequest, releasePR *github.PullRequest, releaseAt time.Time) (desc Description, err error) {
-	regChecked := regexp.MustCompile(`\* +\[x\] +\#(\d+)`)
+func buildDescription(cfg Config, mergedPRs []*PullRequest, releasePR *PullRequest, releaseAt time.Time, compareURL string) (desc Description, err error) {
+	// regChecked detects an already-checked entry anywhere in a line, so a
+	// reviewer's checkmark survives regeneration even inside a grouped or
+	// indented list; it matches both "[x]" and "[X]" since GitHub renders
+	// either as checked.
+	regChecked := regexp.MustCompile(`\* +\[[xX]\] +\#(\d+)`)
 	checked := map[int]bool{}
 	if releasePR != nil {
 		for _, groups := range regChecked.FindAllStringSubmatch(releasePR.GetBody(), -1) {
@@ -217,14 +376,14 @@ func buildDescription(cfg Config, mergedPRs []*github.PullRequest, releasePR *gi
 	}
 
 	// Create title and body of the release pull request
-	desc.Title, err = renderTemplate("title", cfg.Title, cfg, releaseAt, mergedPRs)
+	desc.Title, err = renderTemplate("title", cfg.Title, cfg, releaseAt, mergedPRs, compareURL)
 	if err != nil {
 		return
 	}
 
 	oldBody := strings.TrimSpace(releasePR.GetBody())
 	oldBodyLines := strings.Split(strings.ReplaceAll(regChecked.ReplaceAllString(oldBody, `* [ ] #$1`), "\r\n", "\n"), "\n")
-	newBody, err := renderTemplate("body", cfg.Body, cfg, releaseAt, mergedPRs)
+	newBody, err := renderTemplate("body", cfg.Body, cfg, releaseAt, mergedPRs, compareURL)
 	if err != nil {
 		return
 	}
@@ -262,17 +421,68 @@ func buildDescription(cfg Config, mergedPRs []*github.PullRequest, releasePR *gi
 	return
 }
 
-func renderTemplate(name, text string, cfg Config, releaseAt time.Time, pullRequests []*github.PullRequest) (string, error) {
+func renderTemplate(name, text string, cfg Config, releaseAt time.Time, pullRequests []*PullRequest, compareURL string) (string, error) {
+	return renderTemplateVersioned(name, text, cfg, releaseAt, pullRequests, "", "", compareURL)
+}
+
+// templateFuncs are made available to the title and body templates, in
+// addition to the fields of the data struct built by
+// renderTemplateVersioned.
+var templateFuncs = template.FuncMap{
+	"groupByLabel": groupByLabel,
+	"shortSHA":     shortSHA,
+}
+
+// groupByLabel partitions pullRequests by which of labels they carry, in
+// the form {{ range $group, $prs := groupByLabel .PullRequests "feature" "fix" }}.
+// A pull request carrying more than one of labels appears in each matching
+// group; one carrying none of them is omitted entirely.
+func groupByLabel(pullRequests []*PullRequest, labels ...string) map[string][]*PullRequest {
+	groups := make(map[string][]*PullRequest, len(labels))
+	for _, label := range labels {
+		groups[label] = nil
+	}
+	for _, pr := range pullRequests {
+		for _, label := range pr.Labels {
+			if _, ok := groups[label]; ok {
+				groups[label] = append(groups[label], pr)
+			}
+		}
+	}
+	return groups
+}
+
+// shortSHA truncates a commit SHA to the 7-character form git and GitHub
+// display by default.
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+// renderTemplateVersioned is renderTemplate plus the {{.Version}},
+// {{.PreviousVersion}} and {{.CompareURL}} fields. Version and
+// PreviousVersion are only meaningful once a release is being cut (see
+// runPublish); CompareURL is meaningful whenever cfg.Head and cfg.Base
+// exist on the forge.
+func renderTemplateVersioned(name, text string, cfg Config, releaseAt time.Time, pullRequests []*PullRequest, version, previousVersion, compareURL string) (string, error) {
 	pr := struct {
 		Config
-		ReleaseAt    time.Time
-		PullRequests []*github.PullRequest
+		ReleaseAt       time.Time
+		PullRequests    []*PullRequest
+		Version         string
+		PreviousVersion string
+		CompareURL      string
 	}{
-		Config:       cfg,
-		ReleaseAt:    releaseAt,
-		PullRequests: pullRequests,
-	}
-	tmpl, err := template.New(name).Parse(text)
+		Config:          cfg,
+		ReleaseAt:       releaseAt,
+		PullRequests:    pullRequests,
+		Version:         version,
+		PreviousVersion: previousVersion,
+		CompareURL:      compareURL,
+	}
+	tmpl, err := template.New(name).Funcs(templateFuncs).Parse(text)
 	if err != nil {
 		return "", err
 	}
@@ -284,17 +494,183 @@ func renderTemplate(name, text string, cfg Config, releaseAt time.Time, pullRequ
 	return buf.String(), nil
 }
 
-func main() {
-	flag.Parse()
+// semverRe extracts the first major.minor.patch run from a tag or version
+// string, ignoring any prefix (e.g. "v") or pre-release/build suffix.
+var semverRe = regexp.MustCompile(`(\d+)\.(\d+)\.(\d+)`)
+
+// NextTag returns the next version number after prev, bumped according to
+// bump ("major", "minor" or "patch"; anything else is treated as "patch").
+// prev may carry a leading "v" or other prefix; only the first dotted
+// major.minor.patch run found in it is considered.
+func NextTag(prev string, bump string) string {
+	var major, minor, patch int
+	if groups := semverRe.FindStringSubmatch(prev); groups != nil {
+		major, _ = strconv.Atoi(groups[1])
+		minor, _ = strconv.Atoi(groups[2])
+		patch, _ = strconv.Atoi(groups[3])
+	}
+	switch bump {
+	case "major":
+		major++
+		minor, patch = 0, 0
+	case "minor":
+		minor++
+		patch = 0
+	default:
+		patch++
+	}
+	return fmt.Sprintf("%d.%d.%d", major, minor, patch)
+}
+
+// normalizeVersion strips a leading "v" or other prefix from a tag or
+// branch name, returning just its major.minor.patch run, or "" if none is
+// found (e.g. the repository has no previous release yet).
+func normalizeVersion(s string) string {
+	groups := semverRe.FindStringSubmatch(s)
+	if groups == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s.%s.%s", groups[1], groups[2], groups[3])
+}
+
+func renderTag(cfg Config, version string) (string, error) {
+	tmpl, err := template.New("tag").Parse(cfg.TagTemplate)
+	if err != nil {
+		return "", err
+	}
+	buf := bytes.NewBuffer(nil)
+	err = tmpl.Execute(buf, struct{ Version string }{Version: version})
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// latestTag returns the highest tag of the repository by SemVer 2.0.0
+// precedence, or "" if the repository has no tags yet. The tags API has no
+// documented ordering, so every tag is paginated through and compared via
+// compareVersions rather than trusting the first page. Release tagging is a
+// GitHub-specific feature, so this always goes through the raw
+// github.Client rather than the Forge abstraction.
+func latestTag(ctx context.Context, cfg Config, client *github.Client) (string, error) {
+	var latest string
+	opt := &github.ListOptions{PerPage: 100}
+	for {
+		tags, resp, err := client.Repositories.ListTags(ctx, cfg.Owner, cfg.Repo, opt)
+		if err != nil {
+			return "", err
+		}
+		for _, t := range tags {
+			name := t.GetName()
+			if latest == "" || compareVersions(name, latest) > 0 {
+				latest = name
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	return latest, nil
+}
+
+var regChecklistEntry = regexp.MustCompile(`\* +\[[ xX]\] +\#(\d+)`)
+
+// parsePRNumbers extracts the pull request numbers listed as checklist
+// entries in body, in the order they appear.
+func parsePRNumbers(body string) []int {
+	var numbers []int
+	for _, groups := range regChecklistEntry.FindAllStringSubmatch(body, -1) {
+		n, _ := strconv.Atoi(groups[1])
+		numbers = append(numbers, n)
+	}
+	return numbers
+}
+
+// headSHAMarkerFormat is a hidden HTML comment Sync appends to the release
+// pull request body recording the SHA of cfg.Head the body was generated
+// from, so a later Sync can tell whether cfg.Head has moved since and owes
+// the reviewers an audit-trail comment rather than a silent overwrite.
+const headSHAMarkerFormat = "<!-- gh-pr-release:head-sha:%s -->"
+
+var headSHAMarkerRe = regexp.MustCompile(`<!-- gh-pr-release:head-sha:(\w+) -->`)
+
+// parseHeadSHAMarker extracts the SHA recorded by headSHAMarkerFormat, or
+// "" if body has none.
+func parseHeadSHAMarker(body string) string {
+	groups := headSHAMarkerRe.FindStringSubmatch(body)
+	if groups == nil {
+		return ""
+	}
+	return groups[1]
+}
+
+// summarizeChecklistDiff renders an audit-trail comment listing which pull
+// request numbers are newly added to the release checklist versus already
+// listed, for posting when the release PR's body is regenerated after new
+// commits landed on cfg.Head. It returns "" when nothing was added.
+func summarizeChecklistDiff(oldNumbers, newNumbers []int) string {
+	old := make(map[int]bool, len(oldNumbers))
+	for _, n := range oldNumbers {
+		old[n] = true
+	}
+	var added, kept []int
+	for _, n := range newNumbers {
+		if old[n] {
+			kept = append(kept, n)
+		} else {
+			added = append(added, n)
+		}
+	}
+	if len(added) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("The release checklist was refreshed because new commits landed on the head branch.\n\nNewly added:\n")
+	for _, n := range added {
+		fmt.Fprintf(&b, "* #%d\n", n)
+	}
+	if len(kept) > 0 {
+		b.WriteString("\nAlready listed:\n")
+		for _, n := range kept {
+			fmt.Fprintf(&b, "* #%d\n", n)
+		}
+	}
+	return b.String()
+}
+
+// findMergedReleasePR returns the most recently merged pull request from
+// cfg.Head into cfg.Base, or nil if none has been merged yet.
+func findMergedReleasePR(ctx context.Context, cfg Config, forge Forge) (*PullRequest, error) {
+	prs, err := forge.ListPullRequests(ctx, cfg.Owner, cfg.Repo, PullRequestListOptions{
+		State: "closed",
+		Base:  cfg.Base,
+		Head:  cfg.Head,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, pr := range prs {
+		if pr.MergeCommitSHA != "" {
+			return pr, nil
+		}
+	}
+	return nil, nil
+}
 
-	if *debug {
+// setup loads the configuration and builds the Forge selected by
+// cfg.Forge. ghClient is additionally populated when the forge is backed
+// by the GitHub API (github or ghe), since release management is not yet
+// part of the Forge abstraction; it is nil for other forges.
+func setup(f *commonFlags) (cfg Config, forge Forge, ghClient *github.Client) {
+	if f.debug {
 		log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
 	} else {
 		log.SetFlags(0)
 	}
 
 	// Load configuration
-	cfg, err := loadConfig(*configPath)
+	cfg, err := loadConfig(f.configPath)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -302,11 +678,25 @@ func main() {
 	if err := validate.Struct(&cfg); err != nil {
 		log.Fatal(err)
 	}
+
+	switch cfg.Forge {
+	case "github", "ghe":
+		ghClient = newGitHubClient(&cfg)
+		forge = NewGitHubForge(ghClient)
+	case "gitea":
+		forge = NewGiteaForge(newGiteaClient(&cfg), cfg.APIURL)
+	default:
+		log.Fatalf("unknown forge %q (want \"github\", \"ghe\" or \"gitea\")", cfg.Forge)
+	}
+	return cfg, forge, ghClient
+}
+
+func newGitHubClient(cfg *Config) *github.Client {
 	if cfg.Token == "" {
 		log.Println("Could not obtain GitHub API token.")
 		log.Println("Trying to create new token...")
 
-		token, err := createToken(context.Background())
+		token, err := createGitHubToken(context.Background(), cfg.APIURL)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -316,75 +706,296 @@ func main() {
 		cfg.Token = token
 	}
 
-	// Create a new client of github api with the api token
-	tc := oauth2.NewClient(context.Background(), oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: cfg.Token},
-	))
-	client := github.NewClient(tc)
+	client, err := buildGitHubClient(*cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return client
+}
 
-	// List pull requests which merged into the head branch
-	mergedPRs, err := findMergedPullRequests(context.Background(), cfg, client)
+func newGiteaClient(cfg *Config) *gitea.Client {
+	if cfg.Token == "" {
+		log.Println("Could not obtain Gitea API token.")
+		log.Println("Trying to create new token...")
+
+		token, err := createGiteaToken(cfg.APIURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := saveToken(token); err != nil {
+			log.Fatal(err)
+		}
+		cfg.Token = token
+	}
+
+	client, err := buildGiteaClient(*cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
+	return client
+}
+
+// Sync finds pull requests merged into cfg.Head that aren't released yet
+// and creates or updates the tracking release pull request accordingly.
+// It is the shared core of both the "open" subcommand and serve's
+// webhook-driven updates.
+func Sync(ctx context.Context, cfg Config, forge Forge) error {
+	// List pull requests which merged into the head branch
+	mergedPRs, err := findMergedPullRequests(ctx, cfg, forge)
+	if err != nil {
+		return err
+	}
 	if len(mergedPRs) == 0 {
 		log.Println("No pull requests to be released")
-		return
+		return nil
 	}
 	for _, pr := range mergedPRs {
 		log.Printf("To be released: #%d %s", pr.GetNumber(), pr.GetTitle())
 	}
 
-	// Find the release pull request
-	prs, _, err := client.PullRequests.List(context.Background(), cfg.Owner, cfg.Repo, &github.PullRequestListOptions{
+	// Find the release pull request, aborting if a competing one is
+	// already open from cfg.Head to some other base.
+	prs, err := forge.ListPullRequests(ctx, cfg.Owner, cfg.Repo, PullRequestListOptions{
 		State: "open",
-		Base:  cfg.Base,
-		Head:  fmt.Sprintf("%s:%s", cfg.Owner, cfg.Head),
+		Head:  cfg.Head,
 	})
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	var releasePR *PullRequest
+	for _, pr := range prs {
+		if pr.Base != cfg.Base {
+			return fmt.Errorf("a competing pull request #%d (%s) is already open from %s into %s; close or retarget it before gh-pr-release can manage %s -> %s", pr.Number, pr.HTMLURL, cfg.Head, pr.Base, cfg.Head, cfg.Base)
+		}
+		releasePR = pr
 	}
-	var releasePR *github.PullRequest
-	if len(prs) > 0 {
-		releasePR = prs[0]
+	if releasePR != nil {
 		log.Printf("An existing release pull request #%d found", releasePR.GetNumber())
 	}
 
+	headSHA, err := forge.GetBranchSHA(ctx, cfg.Owner, cfg.Repo, cfg.Head)
+	if err != nil {
+		return err
+	}
+
+	// buildDescription diffs against the old body to preserve checked
+	// checkboxes; strip our own head-SHA marker first so it isn't treated
+	// as checklist content.
+	var descReleasePR *PullRequest
+	if releasePR != nil {
+		stripped := *releasePR
+		stripped.Body = headSHAMarkerRe.ReplaceAllString(releasePR.Body, "")
+		descReleasePR = &stripped
+	}
+
+	compareURL, err := forge.CompareURL(ctx, cfg.Owner, cfg.Repo, cfg.Base, cfg.Head)
+	if err != nil {
+		return err
+	}
+
 	releaseAt := time.Now()
-	desc, err := buildDescription(cfg, mergedPRs, releasePR, releaseAt)
+	desc, err := buildDescription(cfg, mergedPRs, descReleasePR, releaseAt, compareURL)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	desc.Body = strings.TrimRight(desc.Body, "\n") + "\n\n" + fmt.Sprintf(headSHAMarkerFormat, headSHA)
+
 	if releasePR != nil {
+		if oldSHA := parseHeadSHAMarker(releasePR.Body); oldSHA != "" && oldSHA != headSHA {
+			comment := summarizeChecklistDiff(parsePRNumbers(releasePR.Body), parsePRNumbers(desc.Body))
+			if comment != "" {
+				if err := forge.AddComment(ctx, cfg.Owner, cfg.Repo, releasePR.Number, comment); err != nil {
+					return err
+				}
+			}
+		}
+
 		// Update an existing pull request
-		releasePR.Title = &desc.Title
-		releasePR.Body = &desc.Body
-		_, _, err := client.PullRequests.Edit(context.Background(), cfg.Owner, cfg.Repo, releasePR.GetNumber(), releasePR)
+		releasePR.Title = desc.Title
+		releasePR.Body = desc.Body
+		releasePR, err = forge.EditPullRequest(ctx, cfg.Owner, cfg.Repo, releasePR.Number, releasePR)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
-		log.Printf("Updated pull request #%d: %s", releasePR.GetNumber(), releasePR.GetURL())
+		log.Printf("Updated pull request #%d: %s", releasePR.GetNumber(), releasePR.GetHTMLURL())
 	} else {
 		// Create a new pull request
-		releasePR, _, err = client.PullRequests.Create(context.Background(), cfg.Owner, cfg.Repo, &github.NewPullRequest{
-			Title: &desc.Title,
-			Body:  &desc.Body,
-			Head:  &cfg.Head,
-			Base:  &cfg.Base,
+		releasePR, err = forge.CreatePullRequest(ctx, cfg.Owner, cfg.Repo, NewPullRequest{
+			Title: desc.Title,
+			Body:  desc.Body,
+			Head:  cfg.Head,
+			Base:  cfg.Base,
 		})
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
-		log.Printf("Created pull request #%d: %s", releasePR.GetNumber(), releasePR.GetURL())
+		log.Printf("Created pull request #%d: %s", releasePR.GetNumber(), releasePR.GetHTMLURL())
 	}
 
 	if len(cfg.Labels) > 0 {
 		log.Println("Add lables to the pull request")
 
 		// Add labels to the pull request
-		_, _, err := client.Issues.AddLabelsToIssue(context.Background(), cfg.Owner, cfg.Repo, releasePR.GetNumber(), cfg.Labels)
-		if err != nil {
+		if err := forge.AddLabels(ctx, cfg.Owner, cfg.Repo, releasePR.GetNumber(), cfg.Labels); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runOpen(f *commonFlags) {
+	cfg, forge, _ := setup(f)
+	if err := Sync(context.Background(), cfg, forge); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func runPublish(f *publishFlags) {
+	cfg, forge, ghClient := setup(f.commonFlags)
+	if ghClient == nil {
+		log.Fatalf("publish is only supported with a GitHub-compatible forge (got %q)", cfg.Forge)
+	}
+	ctx := context.Background()
+
+	releasePR, err := findMergedReleasePR(ctx, cfg, forge)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if releasePR == nil {
+		log.Fatalf("no merged release pull request found for %s -> %s", cfg.Head, cfg.Base)
+	}
+	log.Printf("Publishing release for merged pull request #%d", releasePR.GetNumber())
+
+	mergedPRs, err := fetchPullRequests(ctx, cfg, forge, parsePRNumbers(releasePR.GetBody()))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	prevTag, err := previousVersion(ctx, cfg, ghClient)
+	if err != nil {
+		log.Fatal(err)
+	}
+	bump := f.bump
+	if bump == "" {
+		bump = computeBump(mergedPRs, cfg.SemverLabels)
+	}
+	version := NextTag(prevTag, bump)
+	tagName, err := renderTag(cfg, version)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	compareURL, err := forge.CompareURL(ctx, cfg.Owner, cfg.Repo, cfg.Base, cfg.Head)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	releaseAt := time.Now()
+	name, err := renderTemplateVersioned("title", cfg.Title, cfg, releaseAt, mergedPRs, version, normalizeVersion(prevTag), compareURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	changelog, err := renderTemplateVersioned("body", cfg.Body, cfg, releaseAt, mergedPRs, version, normalizeVersion(prevTag), compareURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	release, _, err := ghClient.Repositories.CreateRelease(ctx, cfg.Owner, cfg.Repo, &github.RepositoryRelease{
+		TagName:         &tagName,
+		TargetCommitish: &releasePR.MergeCommitSHA,
+		Name:            &name,
+		Body:            &changelog,
+		Draft:           &f.draft,
+		Prerelease:      &f.prerelease,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Created release %s: %s", release.GetTagName(), release.GetHTMLURL())
+
+	for _, path := range f.assets {
+		if err := uploadReleaseAsset(ctx, cfg, ghClient, release, path); err != nil {
 			log.Fatal(err)
 		}
 	}
 }
+
+// fetchPullRequests looks up the pull requests identified by numbers. The
+// Forge interface has no single-PR lookup, so this lists closed pull
+// requests newest-first and filters client-side, stopping once every
+// wanted number has turned up; findMergedPullRequests does the same for
+// the same reason.
+func fetchPullRequests(ctx context.Context, cfg Config, forge Forge, numbers []int) ([]*PullRequest, error) {
+	wanted := make(map[int]bool, len(numbers))
+	for _, n := range numbers {
+		wanted[n] = true
+	}
+	all, err := forge.ListPullRequests(ctx, cfg.Owner, cfg.Repo, PullRequestListOptions{
+		State: "closed",
+		StopEarly: func(soFar []*PullRequest) bool {
+			found := 0
+			for _, pr := range soFar {
+				if wanted[pr.Number] {
+					found++
+				}
+			}
+			return found >= len(wanted)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var prs []*PullRequest
+	for _, pr := range all {
+		if wanted[pr.Number] {
+			prs = append(prs, pr)
+		}
+	}
+	sort.Slice(prs, func(i, j int) bool {
+		return prs[i].Number < prs[j].Number
+	})
+	return prs, nil
+}
+
+func uploadReleaseAsset(ctx context.Context, cfg Config, client *github.Client, release *github.RepositoryRelease, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	asset, _, err := client.Repositories.UploadReleaseAsset(ctx, cfg.Owner, cfg.Repo, release.GetID(), &github.UploadOptions{
+		Name: filepath.Base(path),
+	}, f)
+	if err != nil {
+		return err
+	}
+	log.Printf("Uploaded release asset: %s", asset.GetBrowserDownloadURL())
+	return nil
+}
+
+func main() {
+	args := os.Args[1:]
+	cmd := "open"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd = args[0]
+		args = args[1:]
+	}
+
+	switch cmd {
+	case "open":
+		fs, f := newCommonFlagSet("open")
+		fs.Parse(args)
+		runOpen(f)
+	case "publish":
+		fs, f := newPublishFlagSet()
+		fs.Parse(args)
+		runPublish(f)
+	case "serve":
+		fs, f := newServeFlagSet()
+		fs.Parse(args)
+		runServe(f)
+	default:
+		log.Fatalf("%s: unknown subcommand %q (want \"open\", \"publish\" or \"serve\")", appName, cmd)
+	}
+}
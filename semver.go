@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v27/github"
+)
+
+// bumpRank orders the three semver bump levels so the strongest one wins
+// when multiple pull requests carry different semver labels.
+var bumpRank = map[string]int{"patch": 1, "minor": 2, "major": 3}
+
+// computeBump returns the strongest semver bump implied by the labels of
+// mergedPRs, as mapped by labels (see Config.SemverLabels). It defaults to
+// "patch" when no pull request carries a mapped label.
+func computeBump(mergedPRs []*PullRequest, labels map[string]string) string {
+	bump := "patch"
+	for _, pr := range mergedPRs {
+		for _, label := range pr.Labels {
+			if b, ok := labels[label]; ok && bumpRank[b] > bumpRank[bump] {
+				bump = b
+			}
+		}
+	}
+	return bump
+}
+
+// semverVersion is a version parsed for SemVer 2.0.0 precedence comparison.
+type semverVersion struct {
+	major, minor, patch int
+	preRelease          []string
+}
+
+func parseSemverVersion(s string) semverVersion {
+	s = strings.TrimPrefix(s, "v")
+	core := s
+	var pre string
+	if i := strings.IndexAny(s, "-+"); i >= 0 {
+		core = s[:i]
+		if s[i] == '-' {
+			pre = s[i+1:]
+			if j := strings.IndexByte(pre, '+'); j >= 0 {
+				pre = pre[:j]
+			}
+		}
+	}
+	var v semverVersion
+	parts := strings.SplitN(core, ".", 3)
+	if len(parts) > 0 {
+		v.major, _ = strconv.Atoi(parts[0])
+	}
+	if len(parts) > 1 {
+		v.minor, _ = strconv.Atoi(parts[1])
+	}
+	if len(parts) > 2 {
+		v.patch, _ = strconv.Atoi(parts[2])
+	}
+	if pre != "" {
+		v.preRelease = strings.Split(pre, ".")
+	}
+	return v
+}
+
+func compareInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// comparePreRelease implements SemVer 2.0.0 precedence rule 11: a version
+// with a pre-release has lower precedence than one without; otherwise
+// identifiers are compared left to right (numeric identifiers numerically,
+// others lexically), and the longer set of fields wins a tie.
+func comparePreRelease(a, b []string) int {
+	if len(a) == 0 || len(b) == 0 {
+		return compareInt(len(b), len(a))
+	}
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] == b[i] {
+			continue
+		}
+		an, aErr := strconv.Atoi(a[i])
+		bn, bErr := strconv.Atoi(b[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			return compareInt(an, bn)
+		case aErr == nil:
+			return -1
+		case bErr == nil:
+			return 1
+		case a[i] < b[i]:
+			return -1
+		default:
+			return 1
+		}
+	}
+	return compareInt(len(a), len(b))
+}
+
+// compareVersions compares two version strings by SemVer 2.0.0 precedence,
+// returning a negative number, zero, or a positive number as a is less
+// than, equal to, or greater than b. A leading "v" or other non-numeric
+// prefix is ignored.
+func compareVersions(a, b string) int {
+	va, vb := parseSemverVersion(a), parseSemverVersion(b)
+	if c := compareInt(va.major, vb.major); c != 0 {
+		return c
+	}
+	if c := compareInt(va.minor, vb.minor); c != 0 {
+		return c
+	}
+	if c := compareInt(va.patch, vb.patch); c != 0 {
+		return c
+	}
+	return comparePreRelease(va.preRelease, vb.preRelease)
+}
+
+// listReleaseVersions returns the versions of existing branches whose name
+// starts with prefix, stripped of that prefix and sorted ascending by
+// semver precedence. Release branch listing is GitHub-specific, same as
+// latestTag.
+func listReleaseVersions(ctx context.Context, cfg Config, client *github.Client, prefix string) ([]string, error) {
+	var versions []string
+	opt := &github.ListOptions{PerPage: 100}
+	for {
+		branches, resp, err := client.Repositories.ListBranches(ctx, cfg.Owner, cfg.Repo, opt)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range branches {
+			name := b.GetName()
+			if !strings.HasPrefix(name, prefix) {
+				continue
+			}
+			versions = append(versions, strings.TrimPrefix(name, prefix))
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return compareVersions(versions[i], versions[j]) < 0
+	})
+	return versions, nil
+}
+
+// previousVersion returns the version to bump from: the highest existing
+// release branch matching cfg.ReleaseBranchPrefix, falling back to the
+// latest tag when there are no matching branches.
+func previousVersion(ctx context.Context, cfg Config, client *github.Client) (string, error) {
+	if cfg.ReleaseBranchPrefix != "" {
+		versions, err := listReleaseVersions(ctx, cfg, client, cfg.ReleaseBranchPrefix)
+		if err != nil {
+			return "", err
+		}
+		if len(versions) > 0 {
+			return versions[len(versions)-1], nil
+		}
+	}
+	return latestTag(ctx, cfg, client)
+}
@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"v1.0.0", "1.0.0", 0},
+		{"1.0.0", "2.0.0", -1},
+		{"2.0.0", "1.0.0", 1},
+		{"1.1.0", "1.0.0", 1},
+		{"1.0.1", "1.0.0", 1},
+		{"1.0.0-alpha", "1.0.0", -1},
+		{"1.0.0", "1.0.0-alpha", 1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-alpha.beta", "1.0.0-beta", -1},
+		{"1.0.0-beta", "1.0.0-beta.2", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"1.0.0-beta.11", "1.0.0-rc.1", -1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.0.0+build.1", "1.0.0+build.2", 0},
+	}
+	for _, tt := range tests {
+		if got := sign(compareVersions(tt.a, tt.b)); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// sign collapses a comparison result to -1, 0 or 1 so test cases don't
+// need to know the exact magnitude compareVersions happens to return.
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func TestNextTag(t *testing.T) {
+	tests := []struct {
+		prev, bump, want string
+	}{
+		{"1.2.3", "patch", "1.2.4"},
+		{"1.2.3", "minor", "1.3.0"},
+		{"1.2.3", "major", "2.0.0"},
+		{"v1.2.3", "patch", "1.2.4"},
+		{"1.2.3", "unknown", "1.2.4"},
+		{"", "patch", "0.0.1"},
+		{"release-1.9.9", "minor", "1.10.0"},
+	}
+	for _, tt := range tests {
+		if got := NextTag(tt.prev, tt.bump); got != tt.want {
+			t.Errorf("NextTag(%q, %q) = %q, want %q", tt.prev, tt.bump, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	flag "github.com/spf13/pflag"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+// serveFlags are accepted by the "serve" subcommand in addition to the
+// commonFlags. Its --config points at a ServeConfig rather than a plain
+// Config, since a single deployment services many repositories.
+type serveFlags struct {
+	*commonFlags
+	addr string
+}
+
+func newServeFlagSet() (*flag.FlagSet, *serveFlags) {
+	fs, common := newCommonFlagSet("serve")
+	f := &serveFlags{commonFlags: common}
+	fs.StringVar(&f.addr, "addr", ":8080", "address to listen on")
+	return fs, f
+}
+
+// ServeConfig configures the "serve" subcommand: the shared webhook secret,
+// the debounce window, and the per-repository Config a webhook event is
+// dispatched to, keyed by "owner/repo".
+type ServeConfig struct {
+	WebhookSecret   string            `toml:"webhook_secret"`
+	DebounceSeconds int               `toml:"debounce_seconds"`
+	Repos           map[string]Config `toml:"repos"`
+}
+
+func loadServeConfig(path string) (cfg ServeConfig, err error) {
+	cfg = ServeConfig{DebounceSeconds: 10}
+	if _, err = toml.DecodeFile(path, &cfg); err != nil {
+		return
+	}
+	configDir := filepath.Dir(path)
+	for key, repoCfg := range cfg.Repos {
+		repoCfg = applyConfigDefaults(repoCfg)
+		if repoCfg, err = loadConfigTemplates(repoCfg, configDir); err != nil {
+			return
+		}
+		cfg.Repos[key] = repoCfg
+	}
+	return
+}
+
+// debouncer coalesces repeated Trigger calls for the same key into a single
+// fn invocation, fired delay after the last Trigger for that key.
+type debouncer struct {
+	delay time.Duration
+
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+}
+
+func newDebouncer(delay time.Duration) *debouncer {
+	return &debouncer{delay: delay, timers: map[string]*time.Timer{}}
+}
+
+func (d *debouncer) Trigger(key string, fn func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if t, ok := d.timers[key]; ok {
+		t.Stop()
+	}
+	d.timers[key] = time.AfterFunc(d.delay, fn)
+}
+
+// webhookServer is an http.Handler that verifies GitHub webhook signatures
+// and triggers Sync for the repository a relevant pull_request or push
+// event targets.
+type webhookServer struct {
+	secret    string
+	repos     map[string]Config
+	debouncer *debouncer
+}
+
+// verifyWebhookSignature reports whether signatureHeader (the value of the
+// X-Hub-Signature-256 header) is a valid HMAC-SHA256 signature of body
+// under secret.
+func verifyWebhookSignature(secret string, body []byte, signatureHeader string) bool {
+	const prefix = "sha256="
+	if !strings.HasPrefix(signatureHeader, prefix) {
+		return false
+	}
+	given, err := hex.DecodeString(strings.TrimPrefix(signatureHeader, prefix))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), given)
+}
+
+func (s *webhookServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "cannot read request body", http.StatusBadRequest)
+		return
+	}
+	if !verifyWebhookSignature(s.secret, body, r.Header.Get("X-Hub-Signature-256")) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	var repoFullName, branch string
+	relevant := false
+
+	switch r.Header.Get("X-GitHub-Event") {
+	case "pull_request":
+		var payload struct {
+			Action      string `json:"action"`
+			PullRequest struct {
+				Merged bool `json:"merged"`
+				Base   struct {
+					Ref string `json:"ref"`
+				} `json:"base"`
+			} `json:"pull_request"`
+			Repository struct {
+				FullName string `json:"full_name"`
+			} `json:"repository"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+		repoFullName = payload.Repository.FullName
+		// A pull request "targets cfg.Head" when cfg.Head is its base
+		// branch (i.e. it's a feature PR merging into the head branch this
+		// release PR tracks), not its head branch.
+		branch = payload.PullRequest.Base.Ref
+		relevant = payload.Action == "closed" && payload.PullRequest.Merged
+	case "push":
+		var payload struct {
+			Ref        string `json:"ref"`
+			Repository struct {
+				FullName string `json:"full_name"`
+			} `json:"repository"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+		repoFullName = payload.Repository.FullName
+		branch = strings.TrimPrefix(payload.Ref, "refs/heads/")
+		relevant = true
+	default:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	cfg, ok := s.repos[repoFullName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unconfigured repository %q", repoFullName), http.StatusNotFound)
+		return
+	}
+	if !relevant || branch != cfg.Head {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	log.Printf("%s: scheduling sync for %s -> %s", repoFullName, cfg.Head, cfg.Base)
+	s.debouncer.Trigger(repoFullName, func() {
+		forge, _, err := buildForge(cfg)
+		if err != nil {
+			log.Printf("%s: %v", repoFullName, err)
+			return
+		}
+		if err := Sync(context.Background(), cfg, forge); err != nil {
+			log.Printf("%s: %v", repoFullName, err)
+		}
+	})
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func runServe(f *serveFlags) {
+	if f.debug {
+		log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
+	} else {
+		log.SetFlags(0)
+	}
+
+	cfg, err := loadServeConfig(f.configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.WebhookSecret == "" {
+		log.Fatal("webhook_secret must be set to run serve")
+	}
+	validate := validator.New()
+	for name, repoCfg := range cfg.Repos {
+		if repoCfg.Token == "" {
+			log.Fatalf("%s: token must be set in serve config (no interactive prompt available)", name)
+		}
+		if err := validate.Struct(&repoCfg); err != nil {
+			log.Fatalf("%s: %v", name, err)
+		}
+	}
+
+	srv := &webhookServer{
+		secret:    cfg.WebhookSecret,
+		repos:     cfg.Repos,
+		debouncer: newDebouncer(time.Duration(cfg.DebounceSeconds) * time.Second),
+	}
+	log.Printf("Listening on %s for %d repositories", f.addr, len(cfg.Repos))
+	log.Fatal(http.ListenAndServe(f.addr, srv))
+}